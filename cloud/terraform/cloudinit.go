@@ -0,0 +1,193 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package terraform
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+// BootstrapFormat selects how a node/master's bootstrap payload is
+// rendered. The default, BootstrapFormatBash, is the raw startup script
+// this template has always produced, meant to be curled and piped to
+// bash at first boot. BootstrapFormatCloudConfig and
+// BootstrapFormatIgnition instead render the same install/configure
+// phases as declarative write_files+runcmd/storage.files documents, so
+// Flatcar/CoreOS/Ubuntu cloud images can bootstrap natively through
+// vSphere's guestinfo metadata without a shell interpreter in the loop.
+type BootstrapFormat string
+
+const (
+	// BootstrapFormatBash renders a `#!/bin/bash` script, wrapped in the
+	// `tee /var/log/startup.log` startScript/endScript this template has
+	// always used.
+	BootstrapFormatBash BootstrapFormat = "bash"
+	// BootstrapFormatCloudConfig renders a #cloud-config YAML document.
+	BootstrapFormatCloudConfig BootstrapFormat = "cloud-config"
+	// BootstrapFormatIgnition renders an Ignition v2.2 JSON config.
+	BootstrapFormatIgnition BootstrapFormat = "ignition"
+)
+
+// bootstrapFormat returns the configured bootstrap format, defaulting to BootstrapFormatBash.
+func (p templateParams) bootstrapFormat() BootstrapFormat {
+	if p.BootstrapFormat == "" {
+		return BootstrapFormatBash
+	}
+	return p.BootstrapFormat
+}
+
+// bootstrapFile is one file a bootstrap phase writes to disk: an install
+// script, a configure script, and so on. Both the cloud-config and
+// Ignition renderers walk the same list so the phases stay in lockstep
+// across formats.
+type bootstrapFile struct {
+	Path    string
+	Content string
+	Mode    int
+}
+
+// bootstrapFiles renders the "install" and "configure" phases of t (the
+// node or master template) as a structured list of scripts to write and
+// run, skipping "install" for preloaded images exactly as the bash
+// renderer does.
+func bootstrapFiles(t *template.Template, params templateParams) ([]bootstrapFile, error) {
+	var files []bootstrapFile
+	phase := func(name, path string) error {
+		var buf bytes.Buffer
+		if err := t.ExecuteTemplate(&buf, name, params); err != nil {
+			return err
+		}
+		files = append(files, bootstrapFile{
+			Path:    path,
+			Content: "#!/bin/bash\nset -e\nset -x\n" + buf.String() + "\n",
+			Mode:    0700,
+		})
+		return nil
+	}
+	if !isPreloaded(params) {
+		if err := phase("install", "/opt/cluster-api/10-install.sh"); err != nil {
+			return nil, err
+		}
+	}
+	if err := phase("configure", "/opt/cluster-api/20-configure.sh"); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// renderCloudConfig renders t's install/configure phases as a
+// #cloud-config document: one write_files entry per phase script, and a
+// runcmd list that executes them in order.
+func renderCloudConfig(t *template.Template, params templateParams) (string, error) {
+	files, err := bootstrapFiles(t, params)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("#cloud-config\n\nwrite_files:\n")
+	for _, f := range files {
+		fmt.Fprintf(&buf, "- path: %s\n  permissions: '0%o'\n  content: |\n", f.Path, f.Mode)
+		for _, line := range strings.Split(strings.TrimRight(f.Content, "\n"), "\n") {
+			buf.WriteString("    ")
+			buf.WriteString(line)
+			buf.WriteString("\n")
+		}
+	}
+	buf.WriteString("\nruncmd:\n")
+	for _, f := range files {
+		fmt.Fprintf(&buf, "- %s\n", f.Path)
+	}
+	return buf.String(), nil
+}
+
+// ignitionConfig is a minimal Ignition v2.2 document: just enough to lay
+// down our bootstrap scripts and run them once, in order, via a oneshot
+// systemd unit.
+type ignitionConfig struct {
+	Ignition ignitionVersion `json:"ignition"`
+	Storage  ignitionStorage `json:"storage"`
+	Systemd  ignitionSystemd `json:"systemd"`
+}
+
+type ignitionVersion struct {
+	Version string `json:"version"`
+}
+
+type ignitionStorage struct {
+	Files []ignitionFile `json:"files"`
+}
+
+type ignitionFile struct {
+	Path       string           `json:"path"`
+	Filesystem string           `json:"filesystem"`
+	Mode       int              `json:"mode"`
+	Contents   ignitionContents `json:"contents"`
+}
+
+type ignitionContents struct {
+	Source string `json:"source"`
+}
+
+type ignitionSystemd struct {
+	Units []ignitionUnit `json:"units"`
+}
+
+type ignitionUnit struct {
+	Name     string `json:"name"`
+	Enabled  bool   `json:"enabled"`
+	Contents string `json:"contents"`
+}
+
+// renderIgnition renders t's install/configure phases as an Ignition
+// config: each phase becomes a storage.files entry, and a oneshot
+// "cluster-api-bootstrap.service" unit execs them in order.
+func renderIgnition(t *template.Template, params templateParams) (string, error) {
+	files, err := bootstrapFiles(t, params)
+	if err != nil {
+		return "", err
+	}
+
+	doc := ignitionConfig{Ignition: ignitionVersion{Version: "2.2.0"}}
+	var execLines []string
+	for _, f := range files {
+		doc.Storage.Files = append(doc.Storage.Files, ignitionFile{
+			Path:       f.Path,
+			Filesystem: "root",
+			Mode:       f.Mode,
+			Contents:   ignitionContents{Source: "data:," + url.PathEscape(f.Content)},
+		})
+		execLines = append(execLines, "ExecStart="+f.Path)
+	}
+	unit := "[Unit]\nDescription=cluster-api bootstrap\n[Service]\nType=oneshot\n" +
+		strings.Join(execLines, "\n") + "\n[Install]\nWantedBy=multi-user.target\n"
+	doc.Systemd.Units = append(doc.Systemd.Units, ignitionUnit{
+		Name:     "cluster-api-bootstrap.service",
+		Enabled:  true,
+		Contents: unit,
+	})
+
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
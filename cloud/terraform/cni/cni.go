@@ -0,0 +1,112 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cni provides pluggable CNI providers for the vSphere terraform
+// startup scripts, so the master template no longer has to hard-code
+// Weave as the only network fabric.
+package cni
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Provider renders the install manifest for a CNI plugin, given the
+// cluster's pod CIDR, Kubernetes version, and MTU.
+type Provider interface {
+	// Manifest returns the shell commands (typically a "kubectl apply")
+	// that install this CNI against the cluster's admin kubeconfig.
+	Manifest(podCIDR, kubernetesVersion string, mtu int) string
+}
+
+// legacyAPIs reports whether kubernetesVersion predates 1.16, when several
+// apps/extensions beta API groups that older pinned CNI manifests rely on
+// were removed. Calico, Flannel and Cilium pin to a release known to still
+// use those APIs for clusters that old, and use the latest upstream
+// manifest otherwise.
+func legacyAPIs(kubernetesVersion string) bool {
+	version := strings.TrimPrefix(kubernetesVersion, "v")
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	major, errMajor := strconv.Atoi(parts[0])
+	minor, errMinor := strconv.Atoi(parts[1])
+	return errMajor == nil && errMinor == nil && major == 1 && minor < 16
+}
+
+// Weave installs Weave Net from the cloud.weave.works hosted manifest,
+// matching the behavior this template had before CNI became pluggable.
+type Weave struct{}
+
+// Manifest implements Provider.
+func (Weave) Manifest(podCIDR, kubernetesVersion string, mtu int) string {
+	return fmt.Sprintf(`kubectl apply --kubeconfig /etc/kubernetes/admin.conf -f "https://cloud.weave.works/k8s/net?k8s-version=v%s&env.CHECKPOINT_DISABLE=1&env.IPALLOC_RANGE=%s&env.WEAVE_MTU=%d&disable-npc=true"`, kubernetesVersion, podCIDR, mtu)
+}
+
+// Calico installs the upstream Calico manifest and overrides its pod CIDR
+// and MTU to match the cluster's network configuration.
+type Calico struct{}
+
+// Manifest implements Provider.
+func (Calico) Manifest(podCIDR, kubernetesVersion string, mtu int) string {
+	manifestURL := "https://docs.projectcalico.org/manifests/calico.yaml"
+	if legacyAPIs(kubernetesVersion) {
+		manifestURL = "https://docs.projectcalico.org/v3.8/manifests/calico.yaml"
+	}
+	// The upstream manifest bakes in CALICO_IPV4POOL_CIDR=192.168.0.0/16,
+	// which Calico's startup init container turns into its default IPPool
+	// on first run. Patching the env after apply doesn't recreate that
+	// pool, so the CIDR has to be templated into the manifest before the
+	// first apply instead.
+	return fmt.Sprintf(`curl -sSL -o /tmp/calico.yaml "%s"
+sed -i -e 's|# *- name: CALICO_IPV4POOL_CIDR|- name: CALICO_IPV4POOL_CIDR|' \
+       -e 's|# *value: "192.168.0.0/16"|  value: "%s"|' \
+       -e 's|value: "192.168.0.0/16"|value: "%s"|' /tmp/calico.yaml
+kubectl apply --kubeconfig /etc/kubernetes/admin.conf -f /tmp/calico.yaml
+kubectl set env daemonset/calico-node -n kube-system --kubeconfig /etc/kubernetes/admin.conf FELIX_IPINIPMTU=%d`, manifestURL, podCIDR, podCIDR, mtu)
+}
+
+// Flannel installs the upstream kube-flannel manifest and overrides its
+// net-conf.json pod CIDR to match the cluster's network configuration.
+type Flannel struct{}
+
+// Manifest implements Provider.
+func (Flannel) Manifest(podCIDR, kubernetesVersion string, mtu int) string {
+	ref := "master"
+	if legacyAPIs(kubernetesVersion) {
+		ref = "v0.11.0"
+	}
+	return fmt.Sprintf(`kubectl apply --kubeconfig /etc/kubernetes/admin.conf -f "https://raw.githubusercontent.com/coreos/flannel/%s/Documentation/kube-flannel.yml"
+kubectl patch configmap kube-flannel-cfg -n kube-system --kubeconfig /etc/kubernetes/admin.conf --type merge --patch '{"data":{"net-conf.json":"{\"Network\": \"%s\", \"Backend\": {\"Type\": \"vxlan\"}}"}}'
+kubectl rollout restart daemonset/kube-flannel-ds -n kube-system --kubeconfig /etc/kubernetes/admin.conf`, ref, podCIDR)
+}
+
+// Cilium installs the upstream Cilium quick-install manifest and patches
+// its MTU and pod CIDR to match the cluster's network configuration.
+type Cilium struct{}
+
+// Manifest implements Provider.
+func (Cilium) Manifest(podCIDR, kubernetesVersion string, mtu int) string {
+	version := "v1.9"
+	if legacyAPIs(kubernetesVersion) {
+		version = "v1.6"
+	}
+	return fmt.Sprintf(`kubectl apply --kubeconfig /etc/kubernetes/admin.conf -f "https://raw.githubusercontent.com/cilium/cilium/%s/install/kubernetes/quick-install.yaml"
+kubectl patch configmap cilium-config -n kube-system --kubeconfig /etc/kubernetes/admin.conf --patch '{"data":{"mtu":"%d","cluster-pool-ipv4-cidr":"%s"}}'
+kubectl rollout restart daemonset/cilium -n kube-system --kubeconfig /etc/kubernetes/admin.conf`, version, mtu, podCIDR)
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cni
+
+import "testing"
+
+func TestLegacyAPIs(t *testing.T) {
+	cases := []struct {
+		version string
+		want    bool
+	}{
+		{"1.15.9", true},
+		{"v1.15.9", true},
+		{"1.16.0", false},
+		{"1.24.0", false},
+		{"bogus", false},
+	}
+	for _, c := range cases {
+		if got := legacyAPIs(c.version); got != c.want {
+			t.Errorf("legacyAPIs(%q) = %v, want %v", c.version, got, c.want)
+		}
+	}
+}
+
+func TestManifestUsesKubernetesVersion(t *testing.T) {
+	providers := []Provider{Calico{}, Flannel{}, Cilium{}, Weave{}}
+	for _, p := range providers {
+		legacy := p.Manifest("192.168.0.0/16", "1.15.9", 1450)
+		current := p.Manifest("192.168.0.0/16", "1.24.0", 1450)
+		if legacy == current {
+			t.Errorf("%T.Manifest produced identical output for 1.15.9 and 1.24.0; kubernetesVersion isn't affecting the rendered manifest", p)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package terraform
+
+import "fmt"
+
+// MasterRole distinguishes the master that bootstraps the cluster from
+// masters that join an already-running control plane.
+type MasterRole string
+
+const (
+	// MasterRoleSeed runs `kubeadm init --upload-certs` and is the
+	// default, matching this template's historical single-master
+	// behavior.
+	MasterRoleSeed MasterRole = "seed"
+	// MasterRoleAdditional runs `kubeadm join --control-plane`, joining
+	// the control plane that the seed master already initialized.
+	MasterRoleAdditional MasterRole = "additional"
+)
+
+// masterRole returns the configured master role, defaulting to MasterRoleSeed.
+func (p templateParams) masterRole() MasterRole {
+	if p.MasterRole == "" {
+		return MasterRoleSeed
+	}
+	return p.MasterRole
+}
+
+// controlPlaneEndpoint returns the shared VIP/load-balancer endpoint that
+// masters and nodes should join against, falling back to the seed
+// master's own API endpoint for single-master clusters that don't set
+// one. It errors rather than rendering a blank endpoint, matching the
+// out-of-range panic template execution used to produce before this was
+// pluggable.
+func (p templateParams) controlPlaneEndpoint() (string, error) {
+	if p.ControlPlaneEndpoint != "" {
+		return p.ControlPlaneEndpoint, nil
+	}
+	if len(p.Cluster.Status.APIEndpoints) == 0 {
+		return "", fmt.Errorf("no ControlPlaneEndpoint set and Cluster %q has no status API endpoints", p.Cluster.ObjectMeta.Name)
+	}
+	ep := p.Cluster.Status.APIEndpoints[0]
+	return fmt.Sprintf("%s:%d", ep.Host, ep.Port), nil
+}
+
+// certificateKeyGrepCmd pulls the certificate key kubeadm prints after
+// "Using certificate key:" out of `kubeadm init --upload-certs`'s output.
+// Kept as its own constant so ha_test.go can exercise the exact pipeline
+// runInitWithCertificateKeySteps runs, rather than a copy that could drift.
+const certificateKeyGrepCmd = `grep -A1 'Using certificate key' | tail -n1 | awk '{print $NF}'`
+
+// runInitWithCertificateKeySteps renders the `kubeadm init --upload-certs`
+// invocation for the seed master along with the shell commands that pull
+// the certificate key it prints out of its own output and store it in a
+// kube-system Secret, so whatever controller is driving this cluster's
+// Machines can read the key back out (the same way it already supplies
+// Token) and set it as CertificateKey when rendering an additional
+// master's startup script. It captures kubeadm's own output directly
+// rather than grepping /var/log/startup.log, since that file is only
+// written under the bash bootstrap format.
+const runInitWithCertificateKeySteps = `
+KUBEADM_INIT_OUTPUT=$(kubeadm init --config /etc/kubernetes/kubeadm_config.yaml --upload-certs 2>&1 | tee -a /var/log/kubeadm-init.log)
+echo "${KUBEADM_INIT_OUTPUT}"
+CERTIFICATE_KEY=$(echo "${KUBEADM_INIT_OUTPUT}" | ` + certificateKeyGrepCmd + `)
+kubectl --kubeconfig /etc/kubernetes/admin.conf -n kube-system create secret generic cluster-api-certificate-key --from-literal=certificate-key="${CERTIFICATE_KEY}"
+`
@@ -0,0 +1,62 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package terraform
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestCertificateKeyGrepCmd runs certificateKeyGrepCmd itself against
+// sample kubeadm output, so a regression in the grep/awk pipeline (wrong
+// pattern, wrong field) fails here instead of silently breaking HA joins.
+func TestCertificateKeyGrepCmd(t *testing.T) {
+	cases := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{
+			name: "kubeadm 1.2x upload-certs output",
+			output: `[init] Using Kubernetes version: v1.24.0
+[upload-certs] Storing the certificates in Secret "kubeadm-certs" in the "kube-system" Namespace
+[upload-certs] Using certificate key:
+1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f5a6b7c8d9e0f1a2b
+[mark-control-plane] Marking the node as control-plane`,
+			want: "1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f5a6b7c8d9e0f1a2b",
+		},
+		{
+			name:   "no certificate key present",
+			output: "kubeadm init failed before getting that far",
+			want:   "",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cmd := exec.Command("bash", "-c", "echo \"$INPUT\" | "+certificateKeyGrepCmd)
+			cmd.Env = append(cmd.Environ(), "INPUT="+c.output)
+			out, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("running certificateKeyGrepCmd: %v", err)
+			}
+			if got := strings.TrimSpace(string(out)); got != c.want {
+				t.Errorf("certificateKeyGrepCmd extracted %q, want %q", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package terraform
+
+import "fmt"
+
+// InstallMode selects how kubeadm/kubelet/kubectl get onto a node.
+type InstallMode string
+
+const (
+	// InstallModeAPT installs packages from the apt.kubernetes.io
+	// repository. This is the default, matching this template's
+	// historical behavior.
+	InstallModeAPT InstallMode = "apt"
+	// InstallModeBinary fetches pinned kubeadm/kubelet/kubectl and
+	// cni-plugins tarballs from MirrorBaseURL, verifies them against
+	// Checksums, and lays them down under /usr/bin and /opt/cni/bin. Use
+	// this for air-gapped clusters or enterprise apt mirrors that can't
+	// reach the Google apt repo.
+	InstallModeBinary InstallMode = "binary"
+	// InstallModePreloadedImage skips installation entirely, assuming the
+	// binaries were already baked into the image by
+	// PreloadMasterScript/PreloadNodeScript.
+	InstallModePreloadedImage InstallMode = "preloaded-image"
+)
+
+// defaultMirrorBaseURL is used when templateParams.MirrorBaseURL is empty.
+const defaultMirrorBaseURL = "https://dl.k8s.io"
+
+// defaultCNIPluginsBaseURL is used when templateParams.CNIPluginsBaseURL is
+// empty. cni-plugins are published by containernetworking/plugins on
+// GitHub, not by the Kubernetes release infra MirrorBaseURL points at.
+const defaultCNIPluginsBaseURL = "https://github.com/containernetworking/plugins/releases/download"
+
+// InstallConfig groups the InstallMode-related templateParams fields so
+// callers preloading an image don't have to pass them individually.
+type InstallConfig struct {
+	Mode              InstallMode
+	MirrorBaseURL     string
+	CNIPluginsVersion string
+	CNIPluginsBaseURL string
+	Checksums         map[string]string
+}
+
+// installMode returns the configured install mode, defaulting to InstallModeAPT.
+func (p templateParams) installMode() InstallMode {
+	if p.InstallMode == "" {
+		return InstallModeAPT
+	}
+	return p.InstallMode
+}
+
+// mirrorBaseURL returns the configured binary mirror, defaulting to
+// dl.k8s.io.
+func (p templateParams) mirrorBaseURL() string {
+	if p.MirrorBaseURL == "" {
+		return defaultMirrorBaseURL
+	}
+	return p.MirrorBaseURL
+}
+
+// cniPluginsBaseURL returns the configured cni-plugins mirror, defaulting
+// to the upstream containernetworking/plugins GitHub releases.
+func (p templateParams) cniPluginsBaseURL() string {
+	if p.CNIPluginsBaseURL == "" {
+		return defaultCNIPluginsBaseURL
+	}
+	return p.CNIPluginsBaseURL
+}
+
+// checksum looks up the expected SHA256 sum for a named artifact in
+// Checksums, returning "" if the caller didn't supply one.
+func (p templateParams) checksum(artifact string) string {
+	return p.Checksums[artifact]
+}
+
+// binaryInstallSteps renders the shell commands that download pinned
+// kubeadm/kubelet/kubectl from MirrorBaseURL and cni-plugins from
+// CNIPluginsBaseURL, verify their SHA256 sums against Checksums, and lay
+// them down under /usr/bin and /opt/cni/bin.
+func binaryInstallSteps(p templateParams) string {
+	base := p.mirrorBaseURL()
+	version := p.Machine.Spec.Versions.Kubelet
+	cniBase := p.cniPluginsBaseURL()
+	cniVersion := p.CNIPluginsVersion
+
+	var buf string
+	for _, binary := range []string{"kubeadm", "kubelet", "kubectl"} {
+		buf += fmt.Sprintf(`curl -sSL -o /usr/bin/%[1]s %[2]s/release/v%[3]s/bin/linux/amd64/%[1]s
+echo "%[4]s  /usr/bin/%[1]s" | sha256sum -c -
+chmod a+rx /usr/bin/%[1]s
+`, binary, base, version, p.checksum(binary))
+	}
+
+	buf += fmt.Sprintf(`mkdir -p /opt/cni/bin
+curl -sSL -o /tmp/cni-plugins.tgz %[1]s/v%[2]s/cni-plugins-linux-amd64-v%[2]s.tgz
+echo "%[3]s  /tmp/cni-plugins.tgz" | sha256sum -c -
+tar -C /opt/cni/bin -xzf /tmp/cni-plugins.tgz
+`, cniBase, cniVersion, p.checksum("cni-plugins"))
+
+	return buf
+}
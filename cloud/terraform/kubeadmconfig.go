@@ -0,0 +1,178 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package terraform
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// JoinRole selects which kubeadm join mode a non-seed machine uses.
+type JoinRole string
+
+const (
+	// JoinRoleWorker runs a plain `kubeadm join`, registering the machine
+	// as a worker node. This is the default.
+	JoinRoleWorker JoinRole = "worker"
+	// JoinRoleControlPlane runs `kubeadm join --control-plane`, registering
+	// the machine as an additional master.
+	JoinRoleControlPlane JoinRole = "control-plane"
+)
+
+// joinRole returns the configured join role, defaulting to JoinRoleWorker.
+// MasterRoleAdditional always wins: it's the field a caller actually sets
+// to mark an additional master, so it can't drift out of sync with JoinRole.
+func (p templateParams) joinRole() JoinRole {
+	if p.masterRole() == MasterRoleAdditional {
+		return JoinRoleControlPlane
+	}
+	if p.JoinRole == "" {
+		return JoinRoleWorker
+	}
+	return p.JoinRole
+}
+
+// kubeadmAPIVersion picks the kubeadm.k8s.io API group version that
+// supports the given Kubernetes control-plane version. v1alpha1 was
+// removed in 1.12 and v1beta1 in 1.15; kubeadm keeps at least one prior
+// beta version working via conversion, so we pick the newest one the
+// target version actually ships.
+func kubeadmAPIVersion(controlPlaneVersion string) string {
+	major, minor, ok := majorMinor(controlPlaneVersion)
+	switch {
+	case !ok:
+		return "v1beta2"
+	case major > 1 || minor >= 22:
+		return "v1beta3"
+	case minor >= 15:
+		return "v1beta2"
+	default:
+		return "v1beta1"
+	}
+}
+
+// majorMinor parses the major.minor components out of a version string
+// like "1.18.4" or "v1.18.4".
+func majorMinor(version string) (major, minor int, ok bool) {
+	version = strings.TrimPrefix(version, "v")
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+	major, errMajor := strconv.Atoi(parts[0])
+	minor, errMinor := strconv.Atoi(parts[1])
+	if errMajor != nil || errMinor != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
+// kubeadmInitConfig renders the InitConfiguration + ClusterConfiguration
+// documents that `kubeadm init` on the seed master consumes, replacing the
+// single v1alpha1 MasterConfiguration document this template used to emit.
+// When ControlPlaneEndpoint is set, it's included as both a certSAN (so the
+// apiserver's serving cert actually covers the address every node dials
+// post-join) and as ClusterConfiguration's own controlPlaneEndpoint, so
+// kubeadm advertises it as the cluster's stable address.
+func kubeadmInitConfig(p templateParams) (string, error) {
+	apiVersion := kubeadmAPIVersion(p.Machine.Spec.Versions.ControlPlane)
+	endpoint, err := p.controlPlaneEndpoint()
+	if err != nil {
+		return "", err
+	}
+
+	var extraSAN, clusterEndpoint string
+	if p.ControlPlaneEndpoint != "" {
+		host := endpoint
+		if h, _, splitErr := net.SplitHostPort(endpoint); splitErr == nil {
+			host = h
+		}
+		extraSAN = fmt.Sprintf("\n  - %s", host)
+		clusterEndpoint = fmt.Sprintf("\ncontrolPlaneEndpoint: %s", endpoint)
+	}
+
+	return fmt.Sprintf(`apiVersion: kubeadm.k8s.io/%[1]s
+kind: InitConfiguration
+bootstrapTokens:
+- token: ${TOKEN}
+localAPIEndpoint:
+  advertiseAddress: ${PUBLICIP}
+  bindPort: ${PORT}
+---
+apiVersion: kubeadm.k8s.io/%[1]s
+kind: ClusterConfiguration%[3]s
+networking:
+  serviceSubnet: ${SERVICE_CIDR}
+kubernetesVersion: v${CONTROL_PLANE_VERSION}
+apiServer:
+  certSANs:
+  - ${PUBLICIP}
+  - ${PRIVATEIP}%[2]s
+  extraArgs:
+    cloud-provider: vsphere
+    cloud-config: /etc/kubernetes/cloud-config/cloud-config.yaml
+  extraVolumes:
+  - name: cloud-config
+    hostPath: /etc/kubernetes/cloud-config
+    mountPath: /etc/kubernetes/cloud-config
+controllerManager:
+  extraArgs:
+    cloud-provider: vsphere
+    cloud-config: /etc/kubernetes/cloud-config/cloud-config.yaml
+    address: 0.0.0.0
+  extraVolumes:
+  - name: cloud-config
+    hostPath: /etc/kubernetes/cloud-config
+    mountPath: /etc/kubernetes/cloud-config
+scheduler:
+  extraArgs:
+    address: 0.0.0.0
+`, apiVersion, extraSAN, clusterEndpoint), nil
+}
+
+// kubeadmJoinConfig renders the JoinConfiguration document that
+// `kubeadm join --config` consumes, replacing the raw
+// `kubeadm join --token ... --discovery-token-unsafe-skip-ca-verification`
+// invocation this template used to run. When JoinRole is
+// JoinRoleControlPlane, it includes a controlPlane stanza using
+// CertificateKey so the machine joins as an additional master.
+func kubeadmJoinConfig(p templateParams) (string, error) {
+	endpoint, err := p.controlPlaneEndpoint()
+	if err != nil {
+		return "", err
+	}
+	apiVersion := kubeadmAPIVersion(p.Machine.Spec.Versions.ControlPlane)
+	doc := fmt.Sprintf(`apiVersion: kubeadm.k8s.io/%s
+kind: JoinConfiguration
+discovery:
+  bootstrapToken:
+    apiServerEndpoint: %s
+    token: ${TOKEN}
+    unsafeSkipCAVerification: true
+`, apiVersion, endpoint)
+	if p.joinRole() == JoinRoleControlPlane {
+		doc += `controlPlane:
+  localAPIEndpoint:
+    advertiseAddress: ${PRIVATEIP}
+    bindPort: ${PORT}
+  certificateKey: ${CERTIFICATE_KEY}
+`
+	}
+	return doc, nil
+}
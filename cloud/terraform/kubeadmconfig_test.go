@@ -0,0 +1,66 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package terraform
+
+import "testing"
+
+func TestMajorMinor(t *testing.T) {
+	cases := []struct {
+		name      string
+		version   string
+		wantMajor int
+		wantMinor int
+		wantOK    bool
+	}{
+		{"v-prefixed", "v1.18.4", 1, 18, true},
+		{"no v prefix", "1.24.0", 1, 24, true},
+		{"two components only", "1.16", 1, 16, true},
+		{"unparseable", "not-a-version", 0, 0, false},
+		{"empty", "", 0, 0, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			major, minor, ok := majorMinor(c.version)
+			if ok != c.wantOK || major != c.wantMajor || minor != c.wantMinor {
+				t.Errorf("majorMinor(%q) = (%d, %d, %v), want (%d, %d, %v)",
+					c.version, major, minor, ok, c.wantMajor, c.wantMinor, c.wantOK)
+			}
+		})
+	}
+}
+
+func TestKubeadmAPIVersion(t *testing.T) {
+	cases := []struct {
+		name    string
+		version string
+		want    string
+	}{
+		{"1.14 uses v1beta1", "1.14.0", "v1beta1"},
+		{"1.15 uses v1beta2", "1.15.0", "v1beta2"},
+		{"1.21 still uses v1beta2", "1.21.9", "v1beta2"},
+		{"1.22 uses v1beta3", "1.22.0", "v1beta3"},
+		{"2.0 uses v1beta3", "2.0.0", "v1beta3"},
+		{"unparseable defaults to v1beta2", "bogus", "v1beta2"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := kubeadmAPIVersion(c.version); got != c.want {
+				t.Errorf("kubeadmAPIVersion(%q) = %q, want %q", c.version, got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package terraform
+
+import "testing"
+
+func TestRuntimeConfigKubeletExtraArgs(t *testing.T) {
+	cases := []struct {
+		name           string
+		runtime        RuntimeConfig
+		kubeletVersion string
+		want           string
+	}{
+		{"docker is untouched regardless of version", RuntimeConfig{Runtime: ContainerRuntimeDocker}, "1.24.0", ""},
+		{"containerd pre-1.24 keeps --container-runtime=remote", RuntimeConfig{Runtime: ContainerRuntimeContainerd}, "1.23.5",
+			"--container-runtime=remote --container-runtime-endpoint=unix:///run/containerd/containerd.sock"},
+		{"containerd 1.24+ drops the removed flag", RuntimeConfig{Runtime: ContainerRuntimeContainerd}, "1.24.0",
+			"--container-runtime-endpoint=unix:///run/containerd/containerd.sock"},
+		{"cri-o pre-1.24 keeps --container-runtime=remote", RuntimeConfig{Runtime: ContainerRuntimeCRIO}, "1.18.0",
+			"--container-runtime=remote --container-runtime-endpoint=unix:///var/run/crio/crio.sock"},
+		{"cri-o 1.24+ drops the removed flag", RuntimeConfig{Runtime: ContainerRuntimeCRIO}, "1.25.2",
+			"--container-runtime-endpoint=unix:///var/run/crio/crio.sock"},
+		{"unparseable version defaults to the older, safer flag set", RuntimeConfig{Runtime: ContainerRuntimeContainerd}, "bogus",
+			"--container-runtime=remote --container-runtime-endpoint=unix:///run/containerd/containerd.sock"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.runtime.kubeletExtraArgs(c.kubeletVersion); got != c.want {
+				t.Errorf("kubeletExtraArgs(%q) = %q, want %q", c.kubeletVersion, got, c.want)
+			}
+		})
+	}
+}
@@ -21,15 +21,77 @@ import (
 	"fmt"
 	"text/template"
 
+	"sigs.k8s.io/cluster-api/cloud/terraform/cni"
 	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
 )
 
+// defaultPodNetworkMTU is the MTU passed to every CNI provider's Manifest.
+const defaultPodNetworkMTU = 1450
+
 type templateParams struct {
 	Token        string
 	Cluster      *clusterv1.Cluster
 	Machine      *clusterv1.Machine
 	DockerImages []string
 	Preloaded    bool
+	// Runtime selects the container runtime (docker/moby, containerd,
+	// cri-o) installed on the node and how kubelet talks to it.
+	Runtime RuntimeConfig
+	// CNI selects the pod network provider installed by the master.
+	// Defaults to cni.Weave{} when nil, matching the historical behavior
+	// of this template.
+	CNI cni.Provider
+	// InstallMode selects how kubeadm/kubelet/kubectl are installed.
+	// Defaults to InstallModeAPT when empty.
+	InstallMode InstallMode
+	// MirrorBaseURL is the base URL binaries are fetched from when
+	// InstallMode is InstallModeBinary. Defaults to dl.k8s.io.
+	MirrorBaseURL string
+	// CNIPluginsVersion pins the cni-plugins tarball fetched alongside the
+	// kubeadm/kubelet/kubectl binaries in InstallModeBinary.
+	CNIPluginsVersion string
+	// CNIPluginsBaseURL is the base URL cni-plugins tarballs are fetched
+	// from in InstallModeBinary. cni-plugins are published by
+	// containernetworking/plugins, not by the Kubernetes release infra
+	// MirrorBaseURL points at, so this has its own default and override.
+	CNIPluginsBaseURL string
+	// Checksums maps artifact name (kubeadm, kubelet, kubectl,
+	// cni-plugins) to its expected SHA256 sum, used to verify downloads
+	// in InstallModeBinary.
+	Checksums map[string]string
+	// JoinRole selects whether a `kubeadm join` registers this machine as
+	// a worker or as an additional control-plane node. Defaults to
+	// JoinRoleWorker when empty. Ignored for masters: MasterRoleAdditional
+	// always joins as control-plane regardless of this field.
+	JoinRole JoinRole
+	// CertificateKey is the kubeadm `--upload-certs` key used to decrypt
+	// the control-plane certificates when JoinRole is
+	// JoinRoleControlPlane. The seed master stores this value in the
+	// kube-system/cluster-api-certificate-key Secret (see
+	// runInitWithCertificateKeySteps); the controller rendering an
+	// additional master's startup script is responsible for reading it
+	// back out of that Secret and passing it in here.
+	CertificateKey string
+	// MasterRole selects whether this master seeds the cluster (kubeadm
+	// init) or joins an already-initialized control plane (kubeadm join
+	// --control-plane). Defaults to MasterRoleSeed when empty.
+	MasterRole MasterRole
+	// ControlPlaneEndpoint is the shared VIP/load-balancer address that
+	// masters and nodes join against in an HA cluster. Falls back to the
+	// seed master's own API endpoint when empty.
+	ControlPlaneEndpoint string
+	// BootstrapFormat selects how the bootstrap payload is rendered: a
+	// raw bash script (the default), a #cloud-config document, or an
+	// Ignition config.
+	BootstrapFormat BootstrapFormat
+}
+
+// cniProvider returns the configured CNI provider, defaulting to Weave.
+func (p templateParams) cniProvider() cni.Provider {
+	if p.CNI == nil {
+		return cni.Weave{}
+	}
+	return p.CNI
 }
 
 // Returns the startup script for the nodes.
@@ -59,25 +121,59 @@ func getMasterStartupScript(params templateParams) (string, error) {
 	return buf.String(), nil
 }
 
+// getNodeBootstrap renders the node bootstrap payload in
+// params.BootstrapFormat (bash, cloud-config, or ignition), falling back
+// to getNodeStartupScript's raw bash script when unset.
+func getNodeBootstrap(params templateParams) (string, error) {
+	switch params.bootstrapFormat() {
+	case BootstrapFormatCloudConfig:
+		return renderCloudConfig(nodeStartupScriptTemplate, params)
+	case BootstrapFormatIgnition:
+		return renderIgnition(nodeStartupScriptTemplate, params)
+	default:
+		return getNodeStartupScript(params)
+	}
+}
+
+// getMasterBootstrap renders the master bootstrap payload in
+// params.BootstrapFormat (bash, cloud-config, or ignition), falling back
+// to getMasterStartupScript's raw bash script when unset.
+func getMasterBootstrap(params templateParams) (string, error) {
+	switch params.bootstrapFormat() {
+	case BootstrapFormatCloudConfig:
+		return renderCloudConfig(masterStartupScriptTemplate, params)
+	case BootstrapFormatIgnition:
+		return renderIgnition(masterStartupScriptTemplate, params)
+	default:
+		return getMasterStartupScript(params)
+	}
+}
+
 func isPreloaded(params templateParams) bool {
-	return params.Preloaded
+	return params.Preloaded || params.installMode() == InstallModePreloadedImage
 }
 
 // PreloadMasterScript returns a script that can be used to preload a master.
-func PreloadMasterScript(version string, dockerImages []string) (string, error) {
-	return preloadScript(masterStartupScriptTemplate, version, dockerImages)
+func PreloadMasterScript(version string, dockerImages []string, runtime RuntimeConfig, install InstallConfig) (string, error) {
+	return preloadScript(masterStartupScriptTemplate, version, dockerImages, runtime, install)
 }
 
 // PreloadNodeScript returns a script that can be used to preload a master.
-func PreloadNodeScript(version string, dockerImages []string) (string, error) {
-	return preloadScript(nodeStartupScriptTemplate, version, dockerImages)
+func PreloadNodeScript(version string, dockerImages []string, runtime RuntimeConfig, install InstallConfig) (string, error) {
+	return preloadScript(nodeStartupScriptTemplate, version, dockerImages, runtime, install)
 }
 
-func preloadScript(t *template.Template, version string, dockerImages []string) (string, error) {
+func preloadScript(t *template.Template, version string, dockerImages []string, runtime RuntimeConfig, install InstallConfig) (string, error) {
 	var buf bytes.Buffer
 	params := templateParams{
-		Machine:      &clusterv1.Machine{},
-		DockerImages: dockerImages,
+		Machine:           &clusterv1.Machine{},
+		DockerImages:      dockerImages,
+		Runtime:           runtime,
+		InstallMode:       install.Mode,
+		MirrorBaseURL:     install.MirrorBaseURL,
+		CNIPluginsVersion: install.CNIPluginsVersion,
+		CNIPluginsBaseURL: install.CNIPluginsBaseURL,
+		Checksums:         install.Checksums,
 	}
 	params.Machine.Spec.Versions.Kubelet = version
 	err := t.ExecuteTemplate(&buf, "generatePreloadedImage", params)
@@ -98,8 +194,21 @@ func init() {
 	// reflected in templates below.
 	var _ func(clusterv1.NetworkRanges) string = getSubnet
 	funcMap := map[string]interface{}{
-		"endpoint":  endpoint,
-		"getSubnet": getSubnet,
+		"endpoint":             endpoint,
+		"getSubnet":            getSubnet,
+		"runtimeInstall":       RuntimeConfig.installSteps,
+		"runtimeKubeletArgs":   RuntimeConfig.kubeletExtraArgs,
+		"runtimePull":          RuntimeConfig.pullCommand,
+		"installMode":          templateParams.installMode,
+		"binaryInstallSteps":   binaryInstallSteps,
+		"kubeadmInitConfig":    kubeadmInitConfig,
+		"kubeadmJoinConfig":    kubeadmJoinConfig,
+		"masterRole":           templateParams.masterRole,
+		"controlPlaneEndpoint": templateParams.controlPlaneEndpoint,
+		"cniManifest": func(p templateParams) string {
+			podCIDR := getSubnet(p.Cluster.Spec.ClusterNetwork.Pods)
+			return p.cniProvider().Manifest(podCIDR, p.Machine.Spec.Versions.ControlPlane, defaultPodNetworkMTU)
+		},
 	}
 	nodeStartupScriptTemplate = template.Must(template.New("nodeStartupScript").Funcs(funcMap).Parse(nodeStartupScript))
 	nodeStartupScriptTemplate = template.Must(nodeStartupScriptTemplate.Parse(genericTemplates))
@@ -125,11 +234,8 @@ const genericTemplates = `
   {{ template "startScript" . }}
   {{ template "install" . }}
 
-systemctl enable docker || true
-systemctl start docker || true
-
   {{ range .DockerImages }}
-docker pull {{ . }}
+{{ runtimePull $.Runtime . }}
   {{ end  }}
 
   {{ template "endScript" . }}
@@ -160,32 +266,32 @@ sed -i '/ swap / s/^/#/' /etc/fstab
 
 apt-get update
 apt-get install -y apt-transport-https prips
-apt-key adv --keyserver hkp://keyserver.ubuntu.com --recv-keys F76221572C52609D
 
-cat <<EOF > /etc/apt/sources.list.d/k8s.list
-deb [arch=amd64] https://apt.dockerproject.org/repo ubuntu-xenial main
-EOF
-
-apt-get update
-apt-get install -y docker.io
+{{ runtimeInstall .Runtime }}
 
+{{ if eq (installMode .) "binary" -}}
+{{ binaryInstallSteps . }}
+{{- else -}}
+apt-key adv --keyserver hkp://keyserver.ubuntu.com --recv-keys F76221572C52609D
 curl -s https://packages.cloud.google.com/apt/doc/apt-key.gpg | apt-key add -
 
 cat <<EOF > /etc/apt/sources.list.d/kubernetes.list
 deb http://apt.kubernetes.io/ kubernetes-xenial main
 EOF
 apt-get update
+{{- end }}
 
 {{- end }} {{/* end install */}}
 
 {{ define "configure" -}}
 KUBELET_VERSION={{ .Machine.Spec.Versions.Kubelet }}
 TOKEN={{ .Token }}
-MASTER={{ index .Cluster.Status.APIEndpoints 0 | endpoint }}
+MASTER={{ controlPlaneEndpoint . }}
 MACHINE={{ .Machine.ObjectMeta.Name }}
 CLUSTER_DNS_DOMAIN={{ .Cluster.Spec.ClusterNetwork.ServiceDomain }}
 SERVICE_CIDR={{ getSubnet .Cluster.Spec.ClusterNetwork.Services }}
 
+{{ if eq (installMode .) "apt" -}}
 # Our Debian packages have versions like "1.8.0-00" or "1.8.0-01". Do a prefix
 # search based on our SemVer to find the right (newest) package version.
 function getversion() {
@@ -204,9 +310,7 @@ KUBEADM=$(getversion kubeadm ${KUBELET_VERSION}-)
 KUBECTL=$(getversion kubectl ${KUBELET_VERSION}-)
 # Explicit cni version is a temporary workaround till the right version can be automatically detected correctly
 apt-get install -y kubelet=${KUBELET} kubeadm=${KUBEADM} kubectl=${KUBECTL}
-
-systemctl enable docker || true
-systemctl start docker || true
+{{- end }}
 
 sysctl net.bridge.bridge-nf-call-iptables=1
 
@@ -216,12 +320,16 @@ CLUSTER_DNS_SERVER=$(prips ${SERVICE_CIDR} | head -n 11 | tail -n 1)
 cat > /etc/systemd/system/kubelet.service.d/20-cloud.conf << EOF
 [Service]
 Environment="KUBELET_DNS_ARGS=--cluster-dns=${CLUSTER_DNS_SERVER} --cluster-domain=${CLUSTER_DNS_DOMAIN}"
-Environment="KUBELET_EXTRA_ARGS=--cloud-provider=vsphere"
+Environment="KUBELET_EXTRA_ARGS=--cloud-provider=vsphere {{ runtimeKubeletArgs .Runtime .Machine.Spec.Versions.Kubelet }}"
 EOF
 systemctl daemon-reload
 systemctl restart kubelet.service
 
-kubeadm join --token "${TOKEN}" "${MASTER}" --skip-preflight-checks --discovery-token-unsafe-skip-ca-verification
+cat > /etc/kubernetes/kubeadm_join_config.yaml <<EOF
+{{ kubeadmJoinConfig . }}
+EOF
+
+kubeadm join --config /etc/kubernetes/kubeadm_join_config.yaml
 
 for tries in $(seq 1 60); do
 	kubectl --kubeconfig /etc/kubernetes/kubelet.conf annotate --overwrite node $(hostname) machine=${MACHINE} && break
@@ -239,24 +347,31 @@ sed -i '/ swap / s/^/#/' /etc/fstab
 
 KUBELET_VERSION={{ .Machine.Spec.Versions.Kubelet }}
 
-curl -s https://packages.cloud.google.com/apt/doc/apt-key.gpg | apt-key add -
-touch /etc/apt/sources.list.d/kubernetes.list
-sh -c 'echo "deb http://apt.kubernetes.io/ kubernetes-xenial main" > /etc/apt/sources.list.d/kubernetes.list'
-
 apt-get update -y
 
 apt-get install -y \
     socat \
     ebtables \
-    docker.io \
     apt-transport-https \
     cloud-utils \
     prips
 
+{{ runtimeInstall .Runtime }}
+
+{{ if eq (installMode .) "binary" -}}
+{{ binaryInstallSteps . }}
+{{- else -}}
+curl -s https://packages.cloud.google.com/apt/doc/apt-key.gpg | apt-key add -
+touch /etc/apt/sources.list.d/kubernetes.list
+sh -c 'echo "deb http://apt.kubernetes.io/ kubernetes-xenial main" > /etc/apt/sources.list.d/kubernetes.list'
+apt-get update -y
+
 export VERSION=v${KUBELET_VERSION}
 export ARCH=amd64
 curl -sSL https://dl.k8s.io/release/${VERSION}/bin/linux/${ARCH}/kubeadm > /usr/bin/kubeadm.dl
 chmod a+rx /usr/bin/kubeadm.dl
+{{- end }}
+
 {{- end }} {{/* end install */}}
 
 
@@ -264,6 +379,7 @@ chmod a+rx /usr/bin/kubeadm.dl
 KUBELET_VERSION={{ .Machine.Spec.Versions.Kubelet }}
 TOKEN={{ .Token }}
 PORT=443
+CERTIFICATE_KEY={{ .CertificateKey }}
 MACHINE={{ .Machine.ObjectMeta.Name }}
 CONTROL_PLANE_VERSION={{ .Machine.Spec.Versions.ControlPlane }}
 CLUSTER_DNS_DOMAIN={{ .Cluster.Spec.ClusterNetwork.ServiceDomain }}
@@ -273,6 +389,7 @@ SERVICE_CIDR={{ getSubnet .Cluster.Spec.ClusterNetwork.Services }}
 # kubeadm uses 10th IP as DNS server
 CLUSTER_DNS_SERVER=$(prips ${SERVICE_CIDR} | head -n 11 | tail -n 1)
 
+{{ if eq (installMode .) "apt" -}}
 # Our Debian packages have versions like "1.8.0-00" or "1.8.0-01". Do a prefix
 # search based on our SemVer to find the right (newest) package version.
 function getversion() {
@@ -296,13 +413,12 @@ apt-get install -y \
 
 mv /usr/bin/kubeadm.dl /usr/bin/kubeadm
 chmod a+rx /usr/bin/kubeadm
+{{- end }}
 
-systemctl enable docker
-systemctl start docker
 cat > /etc/systemd/system/kubelet.service.d/20-cloud.conf << EOF
 [Service]
 Environment="KUBELET_DNS_ARGS=--cluster-dns=${CLUSTER_DNS_SERVER} --cluster-domain=${CLUSTER_DNS_DOMAIN}"
-Environment="KUBELET_EXTRA_ARGS=--cloud-provider=vsphere --cloud-config=/etc/kubernetes/cloud-config/cloud-config.yaml"
+Environment="KUBELET_EXTRA_ARGS=--cloud-provider=vsphere --cloud-config=/etc/kubernetes/cloud-config/cloud-config.yaml {{ runtimeKubeletArgs .Runtime .Machine.Spec.Versions.Kubelet }}"
 EOF
 systemctl daemon-reload
 systemctl restart kubelet.service
@@ -312,45 +428,25 @@ echo $PRIVATEIP > /tmp/.ip
 ` +
 	"PUBLICIP=`ip route get 8.8.8.8 | awk '{printf \"%s\", $NF; exit}'`" + `
 
+{{ if eq (masterRole .) "seed" -}}
 # Set up kubeadm config file to pass parameters to kubeadm init.
 cat > /etc/kubernetes/kubeadm_config.yaml <<EOF
-apiVersion: kubeadm.k8s.io/v1alpha1
-kind: MasterConfiguration
-api:
-  advertiseAddress: ${PUBLICIP}
-  bindPort: ${PORT}
-networking:
-  serviceSubnet: ${SERVICE_CIDR}
-kubernetesVersion: v${CONTROL_PLANE_VERSION}
-token: ${TOKEN}
-apiServerCertSANs:
-- ${PUBLICIP}
-- ${PRIVATEIP}
-apiServerExtraArgs:
-  cloud-provider: vsphere
-  cloud-config: /etc/kubernetes/cloud-config/cloud-config.yaml
-apiServerExtraVolumes:
-  - name: cloud-config
-    hostPath: /etc/kubernetes/cloud-config
-    mountPath: /etc/kubernetes/cloud-config
-controllerManagerExtraArgs:
-  cloud-provider: vsphere
-  cloud-config: /etc/kubernetes/cloud-config/cloud-config.yaml
-  address: 0.0.0.0
-schedulerExtraArgs:
-  address: 0.0.0.0
-controllerManagerExtraVolumes:
-  - name: cloud-config
-    hostPath: /etc/kubernetes/cloud-config
-    mountPath: /etc/kubernetes/cloud-config
+{{ kubeadmInitConfig . }}
 EOF
 
-kubeadm init --config /etc/kubernetes/kubeadm_config.yaml
+` + runInitWithCertificateKeySteps + `
 
-# install weavenet
+# install pod network
 sysctl net.bridge.bridge-nf-call-iptables=1
-export kubever=$(kubectl version --kubeconfig /etc/kubernetes/admin.conf | base64 | tr -d '\n')
-kubectl apply --kubeconfig /etc/kubernetes/admin.conf -f "https://cloud.weave.works/k8s/net?env.CHECKPOINT_DISABLE=1&env.IPALLOC_RANGE=${POD_CIDR}&disable-npc=true&k8s-version=$kubever"
+{{ cniManifest . }}
+{{- else -}}
+# Join the control plane that the seed master already initialized.
+cat > /etc/kubernetes/kubeadm_join_config.yaml <<EOF
+{{ kubeadmJoinConfig . }}
+EOF
+
+kubeadm join --config /etc/kubernetes/kubeadm_join_config.yaml
+{{- end }}
 
 for tries in $(seq 1 60); do
 	kubectl --kubeconfig /etc/kubernetes/kubelet.conf annotate --overwrite node $(hostname) machine=${MACHINE} && break